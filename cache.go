@@ -15,14 +15,50 @@ import (
 type Config struct {
 	CachePath string
 	BlockSize int64
+
+	// MaxCapacityBytes bounds the total on-disk footprint of all files
+	// opened through a Cache. Zero means unbounded.
+	MaxCapacityBytes int64
+
+	// PrefetchWorkers is the number of goroutines dispatching queued
+	// block fetches. Zero disables the prefetch pool, so GetBlock
+	// fetches blocks inline as before.
+	PrefetchWorkers int
+
+	// PrefetchWindow is how many blocks past the one requested from
+	// GetBlock are eagerly queued for fetching alongside it.
+	PrefetchWindow int64
+
+	// Shared allows multiple processes to safely use the same
+	// CachePath concurrently. When false (the default), each File
+	// holds a whole-file exclusive lock for its lifetime. When true,
+	// GetBlock and fetchBlock instead take a shared/exclusive lock on
+	// just the block's byte range, letting disjoint blocks be read
+	// concurrently across processes.
+	Shared bool
+
+	// ChecksumInterval enables per-block xxhash checksums: every block
+	// gets a checksum recorded in the manifest when it's written, and
+	// every ChecksumInterval'th GetBlock call re-verifies the current
+	// block against its stored checksum, refetching it on a mismatch.
+	// Zero disables checksumming entirely.
+	ChecksumInterval int64
 }
 
+// Fetcher retrieves the bytes in [start, end) for a cached file.
+type Fetcher func(start int64, end int64) (io.ReadCloser, error)
+
 type Block struct {
 	mapped  []byte
 	written int64
 	once    *sync.Once
 	err     error
 	mutex   *sync.RWMutex
+
+	// atime is the unix nano timestamp of the block's last access,
+	// maintained by the owning Cache's eviction heap.
+	atime     int64
+	heapIndex int
 }
 
 type File struct {
@@ -30,27 +66,90 @@ type File struct {
 	size        int64
 	blockSize   int64
 	blocks      []*Block
-	fetcher     func(start int64, end int64) (io.ReadCloser, error)
+	fetcher     Fetcher
 	failedBlock int32 // defaults to -1, set to the block number of a permanently failed block.
 	mapError    error
 	allocated   *sync.Once
 	mapping     *mmap.MMap
 	handle      *os.File
 	mutex       *sync.RWMutex
+
+	// writtenFlags tracks, per block, whether it has been fully written
+	// (0 or 1, accessed atomically). It backs the manifest sidecar and
+	// is kept separate from each Block's own mutex so it can be read
+	// while a block's fetch is still holding that mutex.
+	writtenFlags []int32
+
+	// cache and name are set when the File was opened through a Cache,
+	// so blocks can report accesses for eviction and admission checks.
+	cache *Cache
+	name  string
+
+	// shared mirrors the owning Cache's Config.Shared, copied in at
+	// open time so lock decisions don't need to dereference cache.
+	shared bool
+
+	// checksumInterval mirrors the owning Cache's Config.ChecksumInterval.
+	checksumInterval int64
+	// checksums holds the xxhash of each fully-written block (accessed
+	// atomically), persisted to the manifest and used to detect
+	// corruption. A zero entry means no checksum has been recorded.
+	checksums   []uint64
+	verifyCalls int64
 }
 
-func Open(pathToFile string, blockSize int64, fetcher func(start int64, end int64) (io.ReadCloser, error), size int64) (*File, error) {
+// blockCount returns the number of blocks needed to cover size bytes at
+// blockSize each.
+func blockCount(size, blockSize int64) int64 {
+	n := size / blockSize
+	if size%blockSize != 0 {
+		n++
+	}
+	return n
+}
+
+// currentOnce returns the block's current fetch gate and mapped length
+// under its mutex. verifyBlock and evictBlock reassign once (and, via
+// Remove, mapped) while holding this same mutex after invalidating a
+// block; reading either field directly without it races with those
+// resets.
+func (b *Block) currentOnce() (*sync.Once, int64) {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.once, int64(len(b.mapped))
+}
+
+func (f *File) isBlockWritten(blockID int64) bool {
+	return atomic.LoadInt32(&f.writtenFlags[blockID]) != 0
+}
+
+func (f *File) markBlockWritten(blockID int64) {
+	atomic.StoreInt32(&f.writtenFlags[blockID], 1)
+	f.flushManifest()
+
+	if f.cache != nil {
+		f.cache.touch(f, blockID)
+	}
+}
+
+func Open(pathToFile string, blockSize int64, fetcher Fetcher, size int64) (*File, error) {
+	return open(pathToFile, blockSize, fetcher, size, false, 0)
+}
+
+func open(pathToFile string, blockSize int64, fetcher Fetcher, size int64, shared bool, checksumInterval int64) (*File, error) {
 	file := &File{
-		pathToFile:  pathToFile,
-		size:        size,
-		blockSize:   blockSize,
-		fetcher:     fetcher,
-		failedBlock: -1,
-		allocated:   new(sync.Once),
-		mutex:       new(sync.RWMutex),
-		mapError:    nil,
-		mapping:     nil,
-		handle:      nil,
+		pathToFile:       pathToFile,
+		size:             size,
+		blockSize:        blockSize,
+		fetcher:          fetcher,
+		failedBlock:      -1,
+		allocated:        new(sync.Once),
+		mutex:            new(sync.RWMutex),
+		mapError:         nil,
+		mapping:          nil,
+		handle:           nil,
+		shared:           shared,
+		checksumInterval: checksumInterval,
 	}
 
 	err := file.allocate()
@@ -62,7 +161,14 @@ func Open(pathToFile string, blockSize int64, fetcher func(start int64, end int6
 }
 
 func (f *File) Remove() error {
+	if f.cache != nil {
+		f.cache.forget(f)
+	}
+
 	f.mutex.Lock()
+	if f.shared {
+		lockRange(f.handle, true, 0, 0)
+	}
 	err1 := f.mapping.Unmap()
 	err2 := f.handle.Close()
 	f.mapError = errors.New("mediacache: file closed")
@@ -75,6 +181,8 @@ func (f *File) Remove() error {
 		block.mutex.Unlock()
 	}
 
+	os.Remove(manifestPath(f.pathToFile))
+
 	if err1 != nil {
 		return err1
 	} else if err2 != nil {
@@ -97,14 +205,30 @@ func (b *Block) Bytes() []byte {
 
 func (f *File) allocate() error {
 	f.allocated.Do(func() {
-		// allocate the file
+		// reuse a prior run's data if its manifest matches what we're
+		// being asked to open, otherwise start from a clean file.
+		existing, _ := loadManifest(manifestPath(f.pathToFile))
+		reuse := existing.matches(f.size, f.blockSize)
+
 		var err error
-		f.handle, err = os.Create(f.pathToFile)
+		if reuse {
+			f.handle, err = os.OpenFile(f.pathToFile, os.O_RDWR, 0644)
+		} else {
+			f.handle, err = os.Create(f.pathToFile)
+		}
 		if err != nil {
-			f.mapError = fmt.Errorf("mediacache: failed to create file %q: %w", f.pathToFile, err)
+			f.mapError = fmt.Errorf("mediacache: failed to open file %q: %w", f.pathToFile, err)
 			return
 		}
 
+		if !f.shared {
+			if err := lockRange(f.handle, true, 0, 0); err != nil {
+				f.handle.Close()
+				f.mapError = fmt.Errorf("mediacache: failed to lock file %q: %w", f.pathToFile, err)
+				return
+			}
+		}
+
 		err = f.handle.Truncate(f.size)
 		if err != nil {
 			f.handle.Close()
@@ -123,12 +247,16 @@ func (f *File) allocate() error {
 
 		f.mapping = &mapping
 
-		numBlocks := f.size / f.blockSize
-		if f.size%f.blockSize != 0 {
-			numBlocks++
-		}
+		numBlocks := blockCount(f.size, f.blockSize)
 
 		f.blocks = make([]*Block, numBlocks)
+		f.writtenFlags = make([]int32, numBlocks)
+		f.checksums = make([]uint64, numBlocks)
+
+		hasChecksums := reuse && int64(len(existing.Checksums)) == numBlocks
+		if hasChecksums {
+			copy(f.checksums, existing.Checksums)
+		}
 
 		for i := range f.blocks {
 			upper := (int64(i) + 1) * f.blockSize
@@ -136,13 +264,36 @@ func (f *File) allocate() error {
 				upper = f.size
 			}
 
-			f.blocks[i] = &Block{
-				mapped:  mapping[int64(i)*f.blockSize : upper],
-				written: 0,
-				once:    new(sync.Once),
-				err:     nil,
-				mutex:   new(sync.RWMutex),
+			block := &Block{
+				mapped:    mapping[int64(i)*f.blockSize : upper],
+				written:   0,
+				once:      new(sync.Once),
+				err:       nil,
+				mutex:     new(sync.RWMutex),
+				heapIndex: -1,
+			}
+
+			if reuse && existing.Written[i] {
+				// A torn write or bit-rot since the manifest was last
+				// flushed means the bytes on disk no longer match what
+				// was recorded; don't trust them, and let GetBlock
+				// refetch the block as if it were never written.
+				valid := !hasChecksums || checksumMatches(block.mapped, existing.Checksums[i])
+
+				if valid {
+					block.written = int64(len(block.mapped))
+					block.once.Do(func() {})
+					f.writtenFlags[i] = 1
+				} else {
+					f.checksums[i] = 0
+				}
 			}
+
+			f.blocks[i] = block
+		}
+
+		if !reuse {
+			f.flushManifest()
 		}
 	})
 
@@ -167,24 +318,64 @@ func (f *File) IsFailed() error {
 	return f.blocks[val].err
 }
 
-func (f *File) GetBlock(blockID int64) (*Block, error) {
+// GetBlock returns the block containing blockID, fetching it first if
+// necessary, along with a release func the caller must call once it's
+// done reading the block's bytes (via Block.Bytes).
+//
+// In shared mode, release doesn't return until the block's byte range
+// has been unlocked; holding that lock from here through release is
+// what actually delivers Config.Shared's cross-process guarantee —
+// unlocking any earlier would let another process take the exclusive
+// lock and punch/rewrite the block out from under an in-progress read.
+// In non-shared mode release is a no-op. Either way it's safe to call
+// more than once.
+func (f *File) GetBlock(blockID int64) (*Block, func(), error) {
 	if blockID >= int64(len(f.blocks)) {
-		return nil, errors.New("mediacache: blockID out of bounds")
+		return nil, nil, errors.New("mediacache: blockID out of bounds")
 	}
 
 	block := f.blocks[blockID]
-	block.once.Do(func() {
+
+	if f.checksumInterval > 0 && atomic.AddInt64(&f.verifyCalls, 1)%f.checksumInterval == 0 {
+		f.verifyBlock(blockID)
+	}
+
+	if f.cache != nil && f.cache.config.PrefetchWorkers > 0 {
+		for id := blockID; id <= blockID+f.cache.config.PrefetchWindow; id++ {
+			f.cache.enqueuePrefetch(f, id)
+		}
+	}
+
+	once, length := block.currentOnce()
+
+	release := func() {}
+	if f.shared {
+		lockRange(f.handle, false, blockID*f.blockSize, length)
+		var unlockOnce sync.Once
+		release = func() {
+			unlockOnce.Do(func() { unlockRange(f.handle, blockID*f.blockSize, length) })
+		}
+	}
+
+	fetched := false
+	once.Do(func() {
 		f.fetchBlock(blockID)
+		fetched = true
 	})
 
+	if f.cache != nil {
+		f.cache.recordAccess(f, blockID, fetched)
+	}
+
 	block.mutex.RLock()
 	if block.err != nil {
 		block.mutex.RUnlock()
-		return nil, block.err
+		release()
+		return nil, nil, block.err
 	}
 	block.mutex.RUnlock()
 
-	return block, nil
+	return block, release, nil
 }
 
 func (f *File) fetchBlock(blockID int64) {
@@ -196,6 +387,11 @@ func (f *File) fetchBlock(blockID int64) {
 		return
 	}
 
+	if f.shared {
+		lockRange(f.handle, true, blockID*f.blockSize, int64(len(block.mapped)))
+		defer unlockRange(f.handle, blockID*f.blockSize, int64(len(block.mapped)))
+	}
+
 	var lastError error
 
 attemptLoop:
@@ -225,6 +421,9 @@ attemptLoop:
 		block.err = nil
 		rd.Close()
 
+		f.recordChecksum(blockID, block)
+		f.markBlockWritten(blockID)
+
 		return
 	}
 
@@ -234,3 +433,117 @@ attemptLoop:
 		block.err = lastError
 	}
 }
+
+// fetchBlockRange fetches a contiguous run of not-yet-fetched blockIDs
+// in a single fetcher call, distributing the returned bytes to each
+// block's region in order as they arrive. If the single fetcher call
+// fails partway, the remaining blocks fall back to fetchBlock so they
+// still get their own retries.
+//
+// A block in the range may already have been fetched inline by a
+// concurrent GetBlock by the time its turn comes up here, in which case
+// its once no-ops and none of the shared stream's bytes are consumed
+// for it. Those bytes are still sitting in rd and must be discarded
+// before moving on, or every block after it would be written from the
+// wrong offset.
+func (f *File) fetchBlockRange(blockIDs []int64) {
+	if len(blockIDs) == 0 {
+		return
+	}
+
+	if len(blockIDs) == 1 {
+		id := blockIDs[0]
+		once, _ := f.blocks[id].currentOnce()
+		once.Do(func() { f.fetchBlock(id) })
+		return
+	}
+
+	start := blockIDs[0] * f.blockSize
+	last := f.blocks[blockIDs[len(blockIDs)-1]]
+	_, lastLength := last.currentOnce()
+	end := blockIDs[len(blockIDs)-1]*f.blockSize + lastLength
+
+	if f.shared {
+		lockRange(f.handle, true, start, end-start)
+		defer unlockRange(f.handle, start, end-start)
+	}
+
+	rd, err := f.fetcher(start, end)
+	if err != nil {
+		for _, id := range blockIDs {
+			id := id
+			once, _ := f.blocks[id].currentOnce()
+			once.Do(func() { f.fetchBlock(id) })
+		}
+		return
+	}
+	defer rd.Close()
+
+	for i, id := range blockIDs {
+		block := f.blocks[id]
+
+		var readErr error
+		ran := false
+		once, length := block.currentOnce()
+		once.Do(func() {
+			ran = true
+
+			block.mutex.Lock()
+			for block.written < int64(len(block.mapped)) {
+				var n int
+				n, readErr = rd.Read(block.mapped[block.written:])
+				block.written += int64(n)
+				if readErr != nil {
+					break
+				}
+			}
+
+			// A Read is allowed to return its final bytes together with
+			// a non-nil error (commonly io.EOF) in the same call; that's
+			// not a failure as long as it filled out the block, so
+			// completeness is judged by written length, not readErr.
+			if block.written == int64(len(block.mapped)) {
+				readErr = nil
+				block.err = nil
+				f.recordChecksum(id, block)
+			}
+			block.mutex.Unlock()
+
+			// The shared stream broke partway through this block, whose
+			// once has now fired; resume it from block.written with
+			// fetchBlock's own retries.
+			if readErr != nil {
+				f.fetchBlock(id)
+			}
+		})
+
+		if !ran {
+			if _, err := io.CopyN(io.Discard, rd, length); err != nil {
+				for _, remaining := range blockIDs[i:] {
+					remaining := remaining
+					remainingOnce, _ := f.blocks[remaining].currentOnce()
+					remainingOnce.Do(func() { f.fetchBlock(remaining) })
+				}
+				return
+			}
+			continue
+		}
+
+		if readErr != nil {
+			block.mutex.Lock()
+			if block.written < int64(len(block.mapped)) {
+				block.err = readErr
+			}
+			block.mutex.Unlock()
+
+			for _, remaining := range blockIDs[i:] {
+				remaining := remaining
+				once, _ := f.blocks[remaining].currentOnce()
+				once.Do(func() { f.fetchBlock(remaining) })
+			}
+			return
+		}
+
+		f.markBlockWritten(id)
+	}
+}
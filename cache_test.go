@@ -27,32 +27,37 @@ func TestCache(t *testing.T) {
 		t.Fatalf("failed to open cache: %v", err)
 	}
 
-	blk, err := f.GetBlock(0)
+	blk, release, err := f.GetBlock(0)
 	if err != nil {
 		t.Fatalf("failed to get block: %v", err)
 	}
+	release()
 
-	blk, err = f.GetBlock(1)
+	blk, release, err = f.GetBlock(1)
 	if err != nil {
 		t.Fatalf("failed to get block: %v", err)
 	}
+	release()
 
-	blk, err = f.GetBlock(2)
+	blk, release, err = f.GetBlock(2)
 	if err != nil {
 		t.Fatalf("failed to get block: %v", err)
 	}
+	release()
 
-	blk, err = f.GetBlock(3)
+	blk, release, err = f.GetBlock(3)
 	if err != nil {
 		t.Fatalf("failed to get block: %v", err)
 	}
+	release()
 
-	blk, err = f.GetBlock(4)
+	blk, release, err = f.GetBlock(4)
 	if err != nil {
 		t.Fatalf("failed to get block: %v", err)
 	}
 
 	fmt.Println(string(blk.Bytes()))
+	release()
 
 	_ = blk
 
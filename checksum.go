@@ -0,0 +1,89 @@
+package mediacache
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// recordChecksum hashes a just-written block and stores it, ready to
+// be persisted by the next flushManifest. Callers must already hold
+// block.mutex.
+func (f *File) recordChecksum(blockID int64, block *Block) {
+	if f.checksumInterval <= 0 {
+		return
+	}
+
+	atomic.StoreUint64(&f.checksums[blockID], xxhash.Sum64(block.mapped))
+}
+
+// checksumMatches reports whether data hashes to want. A want of zero
+// means no checksum was ever recorded for the block, which is treated
+// as trivially valid so checksumming can be turned on after the fact
+// without invalidating existing data.
+func checksumMatches(data []byte, want uint64) bool {
+	return want == 0 || xxhash.Sum64(data) == want
+}
+
+// verifyBlock re-hashes a written block against its stored checksum,
+// and if it no longer matches, resets the block so the next GetBlock
+// refetches it. A block with no stored checksum (never computed, or
+// checksumming just turned on) is treated as trivially valid.
+func (f *File) verifyBlock(blockID int64) bool {
+	block := f.blocks[blockID]
+
+	block.mutex.Lock()
+	defer block.mutex.Unlock()
+
+	if block.written != int64(len(block.mapped)) {
+		return true
+	}
+
+	want := atomic.LoadUint64(&f.checksums[blockID])
+	if want == 0 {
+		return true
+	}
+
+	if xxhash.Sum64(block.mapped) == want {
+		return true
+	}
+
+	block.written = 0
+	block.once = new(sync.Once)
+	atomic.StoreInt32(&f.writtenFlags[blockID], 0)
+	atomic.StoreUint64(&f.checksums[blockID], 0)
+
+	if f.cache != nil {
+		f.cache.untrack(f, blockID)
+	}
+
+	return false
+}
+
+// Verify sweeps every written block, re-hashing it against its stored
+// checksum, and returns the IDs of any that no longer match. It does
+// not reset or refetch corrupted blocks: GetBlock keeps returning a
+// corrupted block's cached bytes unless the call is one of the every
+// ChecksumInterval'th calls that re-verify the block first.
+func (f *File) Verify() []int64 {
+	if f.checksumInterval <= 0 {
+		return nil
+	}
+
+	var corrupted []int64
+
+	for i, block := range f.blocks {
+		block.mutex.RLock()
+		written := block.written == int64(len(block.mapped))
+		want := atomic.LoadUint64(&f.checksums[i])
+		got := xxhash.Sum64(block.mapped)
+		block.mutex.RUnlock()
+
+		if written && want != 0 && got != want {
+			corrupted = append(corrupted, int64(i))
+		}
+	}
+
+	return corrupted
+}
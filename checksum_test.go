@@ -0,0 +1,79 @@
+package mediacache
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+// TestVerifyBlockResetsOnMismatch checks that a block whose on-disk
+// content no longer matches its stored checksum is reset to unwritten,
+// with its stored checksum cleared, so the next GetBlock refetches it,
+// while an intact block is left untouched.
+func TestVerifyBlockResetsOnMismatch(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.bin")
+
+	const blockSize = 100
+	const size = 200 // 2 blocks
+
+	source := bytes.Repeat([]byte("x"), size)
+	fetcher := func(start, end int64) (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(source[start:end])), nil
+	}
+
+	file, err := open(path, blockSize, fetcher, size, false, 1)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+	defer file.Remove()
+
+	if _, release, err := file.GetBlock(0); err != nil {
+		t.Fatalf("failed to get block 0: %v", err)
+	} else {
+		release()
+	}
+	if _, release, err := file.GetBlock(1); err != nil {
+		t.Fatalf("failed to get block 1: %v", err)
+	} else {
+		release()
+	}
+
+	if !file.verifyBlock(0) {
+		t.Fatalf("expected intact block 0 to verify successfully")
+	}
+	if !file.isBlockWritten(0) {
+		t.Fatalf("expected intact block 0 to remain written")
+	}
+
+	// Corrupt block 1's on-disk bytes directly, bypassing GetBlock, as
+	// torn writes or bit-rot would.
+	block := file.blocks[1]
+	block.mutex.Lock()
+	block.mapped[0] ^= 0xff
+	block.mutex.Unlock()
+
+	if file.verifyBlock(1) {
+		t.Fatalf("expected corrupted block 1 to fail verification")
+	}
+	if file.isBlockWritten(1) {
+		t.Fatalf("expected corrupted block 1 to be reset to unwritten")
+	}
+	if atomic.LoadUint64(&file.checksums[1]) != 0 {
+		t.Fatalf("expected corrupted block 1's stored checksum to be cleared")
+	}
+
+	// GetBlock should now refetch block 1 rather than serving the
+	// corrupted bytes.
+	blk, release, err := file.GetBlock(1)
+	if err != nil {
+		t.Fatalf("failed to refetch block 1: %v", err)
+	}
+	defer release()
+
+	if !bytes.Equal(blk.Bytes(), source[blockSize:size]) {
+		t.Fatalf("expected refetched block 1 to match source data")
+	}
+}
@@ -0,0 +1,247 @@
+package mediacache
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"sync/atomic"
+
+	"github.com/cespare/xxhash/v2"
+)
+
+// Handler returns an http.Handler that serves cached files with Range
+// support, opening (and fetching into) a file from the Cache the first
+// time resolver resolves its key for a request.
+//
+// resolver maps an incoming request to the cache key, total size, and
+// Fetcher for the content it should serve.
+func (c *Cache) Handler(resolver func(r *http.Request) (key string, size int64, fetcher Fetcher, err error)) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, size, fetcher, err := resolver(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		file, err := c.OpenFile(key, fetcher, size)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		serveFile(w, r, file)
+	})
+}
+
+func serveFile(w http.ResponseWriter, r *http.Request, file *File) {
+	etag := file.eTag()
+
+	w.Header().Set("Accept-Ranges", "bytes")
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+
+	rangeHeader := r.Header.Get("Range")
+	if ifRange := r.Header.Get("If-Range"); ifRange != "" && ifRange != etag {
+		rangeHeader = ""
+	}
+
+	if rangeHeader == "" {
+		w.Header().Set("Content-Length", strconv.FormatInt(file.size, 10))
+		w.WriteHeader(http.StatusOK)
+		if r.Method != http.MethodHead {
+			writeFileRange(w, file, 0, file.size)
+		}
+		return
+	}
+
+	ranges, err := parseRanges(rangeHeader, file.size)
+	if err != nil {
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", file.size))
+		http.Error(w, err.Error(), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	if len(ranges) == 1 {
+		rg := ranges[0]
+		w.Header().Set("Content-Range", rg.contentRange(file.size))
+		w.Header().Set("Content-Length", strconv.FormatInt(rg.length, 10))
+		w.WriteHeader(http.StatusPartialContent)
+		if r.Method != http.MethodHead {
+			writeFileRange(w, file, rg.start, rg.length)
+		}
+		return
+	}
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/byteranges; boundary="+mw.Boundary())
+	w.WriteHeader(http.StatusPartialContent)
+	if r.Method == http.MethodHead {
+		return
+	}
+
+	for _, rg := range ranges {
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Range": {rg.contentRange(file.size)},
+		})
+		if err != nil {
+			return
+		}
+		if err := writeFileRange(part, file, rg.start, rg.length); err != nil {
+			return
+		}
+	}
+	mw.Close()
+}
+
+// writeFileRange streams [start, start+length) from file's blocks to w,
+// fetching each block on demand via GetBlock.
+func writeFileRange(w io.Writer, file *File, start, length int64) error {
+	if length <= 0 {
+		return nil
+	}
+
+	startBlock := start / file.blockSize
+	endBlock := (start + length - 1) / file.blockSize
+
+	pos := start
+	remaining := length
+
+	for blockID := startBlock; blockID <= endBlock && remaining > 0; blockID++ {
+		block, release, err := file.GetBlock(blockID)
+		if err != nil {
+			return err
+		}
+
+		data := block.Bytes()
+		release()
+
+		offset := pos - blockID*file.blockSize
+		n := int64(len(data)) - offset
+		if n > remaining {
+			n = remaining
+		}
+
+		if _, err := w.Write(data[offset : offset+n]); err != nil {
+			return err
+		}
+
+		pos += n
+		remaining -= n
+	}
+
+	return nil
+}
+
+// eTag derives a weak entity tag for file from its manifest state: the
+// file's dimensions plus each block's written flag and checksum. The
+// tag changes whenever a block becomes written, is evicted or gets a
+// new checksum, so it changes as the cache fills; a request whose
+// If-Range carries an older tag is served the whole file.
+func (f *File) eTag() string {
+	h := xxhash.New()
+
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], uint64(f.size))
+	h.Write(buf[:])
+	binary.LittleEndian.PutUint64(buf[:], uint64(f.blockSize))
+	h.Write(buf[:])
+
+	for i := range f.blocks {
+		if f.isBlockWritten(int64(i)) {
+			h.Write([]byte{1})
+		} else {
+			h.Write([]byte{0})
+		}
+
+		var sum uint64
+		if i < len(f.checksums) {
+			sum = atomic.LoadUint64(&f.checksums[i])
+		}
+		binary.LittleEndian.PutUint64(buf[:], sum)
+		h.Write(buf[:])
+	}
+
+	return fmt.Sprintf(`W/"%x"`, h.Sum64())
+}
+
+// httpRange is a single byte range parsed from a Range header.
+type httpRange struct {
+	start  int64
+	length int64
+}
+
+func (r httpRange) contentRange(size int64) string {
+	return fmt.Sprintf("bytes %d-%d/%d", r.start, r.start+r.length-1, size)
+}
+
+// parseRanges parses the value of a Range header (RFC 7233) against a
+// resource of the given size.
+func parseRanges(header string, size int64) ([]httpRange, error) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, errors.New("mediacache: unsatisfiable range")
+	}
+
+	var ranges []httpRange
+
+	for _, spec := range strings.Split(header[len(prefix):], ",") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+
+		dash := strings.Index(spec, "-")
+		if dash < 0 {
+			return nil, errors.New("mediacache: unsatisfiable range")
+		}
+
+		startStr, endStr := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+		var r httpRange
+		if startStr == "" {
+			n, err := strconv.ParseInt(endStr, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errors.New("mediacache: unsatisfiable range")
+			}
+			if n > size {
+				n = size
+			}
+			r.start = size - n
+			r.length = n
+		} else {
+			start, err := strconv.ParseInt(startStr, 10, 64)
+			if err != nil || start < 0 || start >= size {
+				return nil, errors.New("mediacache: unsatisfiable range")
+			}
+
+			end := size - 1
+			if endStr != "" {
+				end, err = strconv.ParseInt(endStr, 10, 64)
+				if err != nil || end < start {
+					return nil, errors.New("mediacache: unsatisfiable range")
+				}
+				if end >= size {
+					end = size - 1
+				}
+			}
+
+			r.start = start
+			r.length = end - start + 1
+		}
+
+		ranges = append(ranges, r)
+	}
+
+	if len(ranges) == 0 {
+		return nil, errors.New("mediacache: unsatisfiable range")
+	}
+
+	return ranges, nil
+}
@@ -0,0 +1,117 @@
+package mediacache
+
+import "testing"
+
+func TestParseRanges(t *testing.T) {
+	const size = 1000
+
+	tests := []struct {
+		name    string
+		header  string
+		size    int64
+		want    []httpRange
+		wantErr bool
+	}{
+		{
+			name:   "single range",
+			header: "bytes=0-499",
+			size:   size,
+			want:   []httpRange{{start: 0, length: 500}},
+		},
+		{
+			name:   "open-ended range",
+			header: "bytes=500-",
+			size:   size,
+			want:   []httpRange{{start: 500, length: 500}},
+		},
+		{
+			name:   "suffix range",
+			header: "bytes=-500",
+			size:   size,
+			want:   []httpRange{{start: 500, length: 500}},
+		},
+		{
+			name:   "suffix range larger than size",
+			header: "bytes=-5000",
+			size:   size,
+			want:   []httpRange{{start: 0, length: size}},
+		},
+		{
+			name:   "end past size is clamped",
+			header: "bytes=900-5000",
+			size:   size,
+			want:   []httpRange{{start: 900, length: 100}},
+		},
+		{
+			name:   "multipart ranges",
+			header: "bytes=0-99,200-299",
+			size:   size,
+			want: []httpRange{
+				{start: 0, length: 100},
+				{start: 200, length: 100},
+			},
+		},
+		{
+			name:    "start at or past size is unsatisfiable",
+			header:  "bytes=1000-",
+			size:    size,
+			wantErr: true,
+		},
+		{
+			name:    "zero-length suffix is unsatisfiable",
+			header:  "bytes=-0",
+			size:    size,
+			wantErr: true,
+		},
+		{
+			name:    "missing bytes= prefix",
+			header:  "0-499",
+			size:    size,
+			wantErr: true,
+		},
+		{
+			name:    "missing dash",
+			header:  "bytes=500",
+			size:    size,
+			wantErr: true,
+		},
+		{
+			name:    "end before start is unsatisfiable",
+			header:  "bytes=500-100",
+			size:    size,
+			wantErr: true,
+		},
+		{
+			name:    "no specs at all",
+			header:  "bytes=",
+			size:    size,
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseRanges(tt.header, tt.size)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("parseRanges(%q) = %v, want error", tt.header, got)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("parseRanges(%q) returned unexpected error: %v", tt.header, err)
+			}
+
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseRanges(%q) = %+v, want %+v", tt.header, got, tt.want)
+			}
+
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("parseRanges(%q)[%d] = %+v, want %+v", tt.header, i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
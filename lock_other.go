@@ -0,0 +1,16 @@
+//go:build !unix && !windows
+
+package mediacache
+
+import "os"
+
+// lockRange and unlockRange are no-ops on platforms without advisory
+// file locking support; cross-process safety is simply unavailable
+// there.
+func lockRange(f *os.File, exclusive bool, start, length int64) error {
+	return nil
+}
+
+func unlockRange(f *os.File, start, length int64) error {
+	return nil
+}
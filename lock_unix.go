@@ -0,0 +1,39 @@
+//go:build unix
+
+package mediacache
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// lockRange acquires an fcntl(2) POSIX lock on [start, start+length) of
+// f, blocking until it's available. length == 0 means "to the end of
+// the file", per fcntl's own convention.
+func lockRange(f *os.File, exclusive bool, start, length int64) error {
+	lockType := int16(unix.F_RDLCK)
+	if exclusive {
+		lockType = unix.F_WRLCK
+	}
+
+	lock := unix.Flock_t{
+		Type:  lockType,
+		Start: start,
+		Len:   length,
+	}
+
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLKW, &lock)
+}
+
+// unlockRange releases a lock previously taken by lockRange over the
+// same range.
+func unlockRange(f *os.File, start, length int64) error {
+	lock := unix.Flock_t{
+		Type:  unix.F_UNLCK,
+		Start: start,
+		Len:   length,
+	}
+
+	return unix.FcntlFlock(f.Fd(), unix.F_SETLK, &lock)
+}
@@ -0,0 +1,47 @@
+//go:build windows
+
+package mediacache
+
+import (
+	"os"
+
+	"golang.org/x/sys/windows"
+)
+
+// lockRangeMax is used in place of a real length when the caller asks
+// to lock to the end of the file, mirroring fcntl's len-0 convention
+// since LockFileEx has no such shorthand.
+const lockRangeMax = 1<<63 - 1
+
+func splitRange(start, length int64) (offsetLow, offsetHigh, lengthLow, lengthHigh uint32) {
+	if length <= 0 {
+		length = lockRangeMax
+	}
+
+	return uint32(start), uint32(start >> 32), uint32(length), uint32(length >> 32)
+}
+
+// lockRange acquires a LockFileEx lock on [start, start+length) of f,
+// blocking until it's available.
+func lockRange(f *os.File, exclusive bool, start, length int64) error {
+	offsetLow, offsetHigh, lengthLow, lengthHigh := splitRange(start, length)
+
+	var flags uint32
+	if exclusive {
+		flags = windows.LOCKFILE_EXCLUSIVE_LOCK
+	}
+
+	ol := &windows.Overlapped{Offset: offsetLow, OffsetHigh: offsetHigh}
+
+	return windows.LockFileEx(windows.Handle(f.Fd()), flags, 0, lengthLow, lengthHigh, ol)
+}
+
+// unlockRange releases a lock previously taken by lockRange over the
+// same range.
+func unlockRange(f *os.File, start, length int64) error {
+	offsetLow, offsetHigh, lengthLow, lengthHigh := splitRange(start, length)
+
+	ol := &windows.Overlapped{Offset: offsetLow, OffsetHigh: offsetHigh}
+
+	return windows.UnlockFileEx(windows.Handle(f.Fd()), 0, lengthLow, lengthHigh, ol)
+}
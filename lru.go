@@ -0,0 +1,338 @@
+package mediacache
+
+import (
+	"container/heap"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Stats is a point-in-time snapshot of a Cache's usage counters.
+type Stats struct {
+	BytesUsed    int64
+	BytesEvicted int64
+	Hits         int64
+	Misses       int64
+}
+
+// Cache owns a directory of cached Files and enforces a shared capacity
+// budget across them, evicting the least-recently-used blocks once the
+// budget is exceeded.
+type Cache struct {
+	config *Config
+
+	mutex sync.RWMutex
+	files map[string]*File
+
+	heapMutex sync.Mutex
+	heap      blockHeap
+
+	bytesUsed    int64
+	bytesEvicted int64
+	hits         int64
+	misses       int64
+
+	evictTrigger chan struct{}
+	done         chan struct{}
+	wg           sync.WaitGroup
+
+	prefetchMutex    sync.Mutex
+	prefetchCond     *sync.Cond
+	prefetchHeap     fetchHeap
+	prefetchQueued   map[fetchKey]bool
+	prefetchSequence int64
+}
+
+// NewCache creates a Cache rooted at config.CachePath and starts its
+// background eviction goroutine. Call Close to stop it.
+func NewCache(config *Config) *Cache {
+	c := &Cache{
+		config:         config,
+		files:          make(map[string]*File),
+		evictTrigger:   make(chan struct{}, 1),
+		done:           make(chan struct{}),
+		prefetchQueued: make(map[fetchKey]bool),
+	}
+	c.prefetchCond = sync.NewCond(&c.prefetchMutex)
+
+	c.wg.Add(1)
+	go c.evictLoop()
+
+	for i := 0; i < config.PrefetchWorkers; i++ {
+		c.wg.Add(1)
+		go c.prefetchWorker()
+	}
+
+	return c
+}
+
+// OpenFile opens (or reuses) the cached file named name within the
+// Cache, fetching missing bytes with fetcher as they're requested.
+func (c *Cache) OpenFile(name string, fetcher Fetcher, size int64) (*File, error) {
+	c.mutex.Lock()
+	if existing, ok := c.files[name]; ok {
+		c.mutex.Unlock()
+		return existing, nil
+	}
+	c.mutex.Unlock()
+
+	file, err := open(filepath.Join(c.config.CachePath, name), c.config.BlockSize, fetcher, size,
+		c.config.Shared, c.config.ChecksumInterval)
+	if err != nil {
+		return nil, err
+	}
+	file.cache = c
+	file.name = name
+
+	// Blocks reused from a prior run's manifest are already on disk
+	// and already counted towards written, but touch has never run for
+	// them (there was no markBlockWritten call this process) — seed
+	// the heap now so they're eligible for eviction like any other
+	// cached block.
+	for i := range file.blocks {
+		c.touch(file, int64(i))
+	}
+
+	c.mutex.Lock()
+	c.files[name] = file
+	c.mutex.Unlock()
+
+	c.triggerEviction()
+
+	return file, nil
+}
+
+// Stats returns a snapshot of the Cache's usage counters.
+func (c *Cache) Stats() Stats {
+	return Stats{
+		BytesUsed:    atomic.LoadInt64(&c.bytesUsed),
+		BytesEvicted: atomic.LoadInt64(&c.bytesEvicted),
+		Hits:         atomic.LoadInt64(&c.hits),
+		Misses:       atomic.LoadInt64(&c.misses),
+	}
+}
+
+// Close stops the background eviction goroutine. It does not close or
+// remove any files still open in the Cache.
+func (c *Cache) Close() error {
+	close(c.done)
+
+	c.prefetchMutex.Lock()
+	c.prefetchCond.Broadcast()
+	c.prefetchMutex.Unlock()
+
+	c.wg.Wait()
+	return nil
+}
+
+func (c *Cache) forget(f *File) {
+	c.mutex.Lock()
+	delete(c.files, f.name)
+	c.mutex.Unlock()
+
+	c.heapMutex.Lock()
+	for _, block := range f.blocks {
+		if block.heapIndex >= 0 {
+			removed := heap.Remove(&c.heap, block.heapIndex).(*block_)
+			atomic.AddInt64(&c.bytesUsed, -int64(len(removed.block.mapped)))
+		}
+	}
+	c.heapMutex.Unlock()
+}
+
+// untrack removes a single block from the eviction heap and reconciles
+// bytesUsed, without touching the block's on-disk state. It's for
+// invalidation paths outside normal eviction (e.g. a failed checksum
+// verification resetting a block to unwritten) — without this, the
+// block's bytes would stay counted against the budget forever, since
+// evictBlock's own accounting only runs for blocks it pops itself.
+func (c *Cache) untrack(f *File, blockID int64) {
+	block := f.blocks[blockID]
+
+	c.heapMutex.Lock()
+	if block.heapIndex >= 0 {
+		removed := heap.Remove(&c.heap, block.heapIndex).(*block_)
+		atomic.AddInt64(&c.bytesUsed, -int64(len(removed.block.mapped)))
+	}
+	c.heapMutex.Unlock()
+}
+
+// recordAccess tracks a GetBlock call's hit/miss outcome and bumps the
+// block's last-access time. Accounting the block's bytes against the
+// capacity budget happens separately, in touch, at the point the block
+// actually transitions to written — not here — since a block can become
+// written via the prefetch pool's fetchBlockRange without this ever
+// being called with fetched==true for it.
+func (c *Cache) recordAccess(f *File, blockID int64, fetched bool) {
+	if fetched {
+		atomic.AddInt64(&c.misses, 1)
+	} else {
+		atomic.AddInt64(&c.hits, 1)
+	}
+
+	c.touch(f, blockID)
+}
+
+// touch bumps a block's last-access time in the eviction heap. The
+// first time it's called for a given block (recognized by the block
+// not already being in the heap), it also accounts the block's bytes
+// against the capacity budget — this is the single place that happens,
+// regardless of whether the block was filled by an inline GetBlock
+// fetch, the prefetch pool, or was already on disk from a reused
+// manifest. Blocks that aren't fully written yet (including ones that
+// failed to fetch) are left out of the heap entirely.
+func (c *Cache) touch(f *File, blockID int64) {
+	if !f.isBlockWritten(blockID) {
+		return
+	}
+
+	block := f.blocks[blockID]
+
+	now := time.Now().UnixNano()
+	atomic.StoreInt64(&block.atime, now)
+
+	c.heapMutex.Lock()
+	if block.heapIndex < 0 {
+		atomic.AddInt64(&c.bytesUsed, int64(len(block.mapped)))
+		heap.Push(&c.heap, &block_{file: f, block: block})
+	} else {
+		heap.Fix(&c.heap, block.heapIndex)
+	}
+	c.heapMutex.Unlock()
+
+	c.triggerEviction()
+}
+
+func (c *Cache) triggerEviction() {
+	if c.config.MaxCapacityBytes <= 0 {
+		return
+	}
+
+	select {
+	case c.evictTrigger <- struct{}{}:
+	default:
+	}
+}
+
+func (c *Cache) evictLoop() {
+	defer c.wg.Done()
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-c.evictTrigger:
+			c.evict()
+		case <-ticker.C:
+			c.evict()
+		}
+	}
+}
+
+// evict pops the least-recently-used blocks until usage is back under
+// the configured budget.
+func (c *Cache) evict() {
+	if c.config.MaxCapacityBytes <= 0 {
+		return
+	}
+
+	for atomic.LoadInt64(&c.bytesUsed) > c.config.MaxCapacityBytes {
+		c.heapMutex.Lock()
+		if c.heap.Len() == 0 {
+			c.heapMutex.Unlock()
+			return
+		}
+		item := heap.Pop(&c.heap).(*block_)
+		c.heapMutex.Unlock()
+
+		n := c.evictBlock(item.file, item.block)
+		atomic.AddInt64(&c.bytesUsed, -n)
+		atomic.AddInt64(&c.bytesEvicted, n)
+	}
+}
+
+// evictBlock punches a hole over the block's region of the underlying
+// file and resets it so the next GetBlock refetches it.
+func (c *Cache) evictBlock(f *File, block *Block) int64 {
+	block.mutex.Lock()
+	defer block.mutex.Unlock()
+
+	if block.written == 0 {
+		return 0
+	}
+
+	n := int64(len(block.mapped))
+
+	blockID := int64(-1)
+	for i, b := range f.blocks {
+		if b == block {
+			blockID = int64(i)
+			break
+		}
+	}
+
+	f.mutex.RLock()
+	if f.handle != nil && blockID >= 0 {
+		punchHole(f.handle, blockID*f.blockSize, n)
+	}
+	f.mutex.RUnlock()
+
+	for i := range block.mapped {
+		block.mapped[i] = 0
+	}
+	block.written = 0
+	block.once = new(sync.Once)
+	block.heapIndex = -1
+
+	if blockID >= 0 {
+		atomic.StoreInt32(&f.writtenFlags[blockID], 0)
+	}
+	f.flushManifest()
+
+	return n
+}
+
+// block_ is an entry in the Cache's block heap. It's named with a
+// trailing underscore because a type named block would be shadowed by
+// the local variables of that name, such as the loop variable in forget.
+type block_ struct {
+	file  *File
+	block *Block
+}
+
+// blockHeap is a container/heap.Interface ordering blocks by oldest
+// access time first, so Pop always returns the least-recently-used
+// block across every File in the Cache.
+type blockHeap []*block_
+
+func (h blockHeap) Len() int { return len(h) }
+
+func (h blockHeap) Less(i, j int) bool {
+	return atomic.LoadInt64(&h[i].block.atime) < atomic.LoadInt64(&h[j].block.atime)
+}
+
+func (h blockHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].block.heapIndex = i
+	h[j].block.heapIndex = j
+}
+
+func (h *blockHeap) Push(x interface{}) {
+	item := x.(*block_)
+	item.block.heapIndex = len(*h)
+	*h = append(*h, item)
+}
+
+func (h *blockHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	item.block.heapIndex = -1
+	*h = old[:n-1]
+	return item
+}
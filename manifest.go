@@ -0,0 +1,107 @@
+package mediacache
+
+import (
+	"encoding/json"
+	"os"
+	"sync/atomic"
+)
+
+// manifestSuffix is appended to a cached file's path to form the path
+// of its sidecar manifest.
+const manifestSuffix = ".mediacache"
+
+// manifest records enough state about a cached file to reopen it
+// without refetching blocks that were already fully written.
+type manifest struct {
+	Size      int64    `json:"size"`
+	BlockSize int64    `json:"blockSize"`
+	Written   []bool   `json:"written"`
+	Checksums []uint64 `json:"checksums,omitempty"`
+}
+
+func manifestPath(pathToFile string) string {
+	return pathToFile + manifestSuffix
+}
+
+// loadManifest reads and parses the manifest at path. A missing file is
+// not an error; it simply returns a nil manifest.
+func loadManifest(path string) (*manifest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, nil
+	}
+
+	return &m, nil
+}
+
+// matches reports whether m describes a file with the same dimensions
+// as size/blockSize, and therefore can be reused as-is. A manifest that
+// carries a checksum column must have one entry per block; a shorter or
+// longer column means the manifest itself was torn or truncated, and
+// the reused bitmap can't be trusted to line up with it.
+func (m *manifest) matches(size, blockSize int64) bool {
+	if m == nil {
+		return false
+	}
+
+	if m.Size != size || m.BlockSize != blockSize {
+		return false
+	}
+
+	if int64(len(m.Written)) != blockCount(size, blockSize) {
+		return false
+	}
+
+	if len(m.Checksums) != 0 && int64(len(m.Checksums)) != blockCount(size, blockSize) {
+		return false
+	}
+
+	return true
+}
+
+// writeManifestAtomic writes m to path via a temp file plus rename, so
+// a crash mid-write never leaves a torn manifest behind.
+func writeManifestAtomic(path string, m *manifest) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// flushManifest persists the current written-bitmap for f. It's
+// best-effort: a failure here just means the next restart refetches
+// more than strictly necessary, so it doesn't fail the caller.
+func (f *File) flushManifest() {
+	m := manifest{
+		Size:      f.size,
+		BlockSize: f.blockSize,
+		Written:   make([]bool, len(f.blocks)),
+	}
+
+	for i := range f.blocks {
+		m.Written[i] = f.isBlockWritten(int64(i))
+	}
+
+	if f.checksumInterval > 0 {
+		m.Checksums = make([]uint64, len(f.checksums))
+		for i := range f.checksums {
+			m.Checksums[i] = atomic.LoadUint64(&f.checksums[i])
+		}
+	}
+
+	writeManifestAtomic(manifestPath(f.pathToFile), &m)
+}
@@ -0,0 +1,134 @@
+package mediacache
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestManifestMatches(t *testing.T) {
+	base := &manifest{
+		Size:      5000,
+		BlockSize: 1024,
+		Written:   make([]bool, blockCount(5000, 1024)),
+	}
+
+	if !base.matches(5000, 1024) {
+		t.Fatalf("expected matching size/blockSize/written length to match")
+	}
+
+	if base.matches(4000, 1024) {
+		t.Fatalf("expected mismatched size not to match")
+	}
+
+	if base.matches(5000, 512) {
+		t.Fatalf("expected mismatched blockSize not to match")
+	}
+
+	truncated := &manifest{
+		Size:      5000,
+		BlockSize: 1024,
+		Written:   base.Written[:len(base.Written)-1],
+	}
+	if truncated.matches(5000, 1024) {
+		t.Fatalf("expected truncated written bitmap not to match")
+	}
+
+	withChecksums := &manifest{
+		Size:      5000,
+		BlockSize: 1024,
+		Written:   base.Written,
+		Checksums: make([]uint64, len(base.Written)),
+	}
+	if !withChecksums.matches(5000, 1024) {
+		t.Fatalf("expected full-length checksum column to match")
+	}
+
+	truncatedChecksums := &manifest{
+		Size:      5000,
+		BlockSize: 1024,
+		Written:   base.Written,
+		Checksums: make([]uint64, len(base.Written)-1),
+	}
+	if truncatedChecksums.matches(5000, 1024) {
+		t.Fatalf("expected truncated checksum column not to match")
+	}
+
+	var nilManifest *manifest
+	if nilManifest.matches(5000, 1024) {
+		t.Fatalf("expected nil manifest not to match")
+	}
+}
+
+// TestManifestRoundTripReuse verifies that reopening a cached file at the
+// same path picks up the manifest's written bitmap and doesn't refetch
+// blocks that were already fully written in a prior run.
+func TestManifestRoundTripReuse(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "video.bin")
+
+	const blockSize = 100
+	const size = 250 // 3 blocks: 100, 100, 50
+
+	source := bytes.Repeat([]byte("x"), size)
+
+	fetchCalls := make(map[int64]int)
+	fetcher := func(start, end int64) (io.ReadCloser, error) {
+		fetchCalls[start]++
+		return io.NopCloser(bytes.NewReader(source[start:end])), nil
+	}
+
+	file1, err := open(path, blockSize, fetcher, size, false, 0)
+	if err != nil {
+		t.Fatalf("failed to open file: %v", err)
+	}
+
+	if _, release, err := file1.GetBlock(0); err != nil {
+		t.Fatalf("failed to get block 0: %v", err)
+	} else {
+		release()
+	}
+	if _, release, err := file1.GetBlock(1); err != nil {
+		t.Fatalf("failed to get block 1: %v", err)
+	} else {
+		release()
+	}
+
+	// Tear down file1 without deleting its manifest, as if the process
+	// had simply restarted.
+	file1.mapping.Unmap()
+	unlockRange(file1.handle, 0, 0)
+	file1.handle.Close()
+
+	file2, err := open(path, blockSize, fetcher, size, false, 0)
+	if err != nil {
+		t.Fatalf("failed to reopen file: %v", err)
+	}
+	defer file2.Remove()
+
+	if !file2.isBlockWritten(0) || !file2.isBlockWritten(1) {
+		t.Fatalf("expected blocks 0 and 1 to be reused as already written")
+	}
+	if file2.isBlockWritten(2) {
+		t.Fatalf("expected block 2 to still be unwritten")
+	}
+
+	if _, release, err := file2.GetBlock(0); err != nil {
+		t.Fatalf("failed to get reused block 0: %v", err)
+	} else {
+		release()
+	}
+	if fetchCalls[0*blockSize] != 1 {
+		t.Fatalf("expected reused block 0 not to be refetched, fetcher called %d times", fetchCalls[0*blockSize])
+	}
+
+	if _, release, err := file2.GetBlock(2); err != nil {
+		t.Fatalf("failed to get block 2: %v", err)
+	} else {
+		release()
+	}
+	if fetchCalls[2*blockSize] != 1 {
+		t.Fatalf("expected block 2 to be fetched exactly once, got %d", fetchCalls[2*blockSize])
+	}
+}
@@ -0,0 +1,131 @@
+package mediacache
+
+import "container/heap"
+
+// fetchKey identifies a single (file, block) pair in the prefetch
+// queue's dedup set.
+type fetchKey struct {
+	name    string
+	blockID int64
+}
+
+// fetchTask is a queued request to fetch a block, ordered by the order
+// it was requested in so nearer/earlier requests are served first.
+type fetchTask struct {
+	file      *File
+	blockID   int64
+	sequence  int64
+	heapIndex int
+}
+
+// fetchHeap is a container/heap.Interface ordering tasks by sequence,
+// oldest first.
+type fetchHeap []*fetchTask
+
+func (h fetchHeap) Len() int           { return len(h) }
+func (h fetchHeap) Less(i, j int) bool { return h[i].sequence < h[j].sequence }
+func (h fetchHeap) Swap(i, j int) {
+	h[i], h[j] = h[j], h[i]
+	h[i].heapIndex = i
+	h[j].heapIndex = j
+}
+
+func (h *fetchHeap) Push(x interface{}) {
+	task := x.(*fetchTask)
+	task.heapIndex = len(*h)
+	*h = append(*h, task)
+}
+
+func (h *fetchHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	task := old[n-1]
+	old[n-1] = nil
+	task.heapIndex = -1
+	*h = old[:n-1]
+	return task
+}
+
+// enqueuePrefetch queues blockID of f to be fetched by the worker pool,
+// unless it's already fully written or already queued.
+func (c *Cache) enqueuePrefetch(f *File, blockID int64) {
+	if blockID < 0 || blockID >= int64(len(f.blocks)) || f.isBlockWritten(blockID) {
+		return
+	}
+
+	key := fetchKey{name: f.name, blockID: blockID}
+
+	c.prefetchMutex.Lock()
+	defer c.prefetchMutex.Unlock()
+
+	if c.prefetchQueued[key] {
+		return
+	}
+	c.prefetchQueued[key] = true
+
+	c.prefetchSequence++
+	heap.Push(&c.prefetchHeap, &fetchTask{file: f, blockID: blockID, sequence: c.prefetchSequence})
+	c.prefetchCond.Signal()
+}
+
+const prefetchBatchSize = 8
+
+// prefetchWorker pulls tasks from the queue, coalescing a run of
+// contiguous blocks from the same file into one fetchBlockRange call.
+func (c *Cache) prefetchWorker() {
+	defer c.wg.Done()
+
+	for {
+		c.prefetchMutex.Lock()
+		for c.prefetchHeap.Len() == 0 {
+			select {
+			case <-c.done:
+				c.prefetchMutex.Unlock()
+				return
+			default:
+			}
+			c.prefetchCond.Wait()
+		}
+
+		select {
+		case <-c.done:
+			c.prefetchMutex.Unlock()
+			return
+		default:
+		}
+
+		task := heap.Pop(&c.prefetchHeap).(*fetchTask)
+		delete(c.prefetchQueued, fetchKey{name: task.file.name, blockID: task.blockID})
+
+		blockIDs := []int64{task.blockID}
+		for len(blockIDs) < prefetchBatchSize {
+			next := c.popQueuedBlock(task.file, blockIDs[len(blockIDs)-1]+1)
+			if next == nil {
+				break
+			}
+			blockIDs = append(blockIDs, next.blockID)
+		}
+		c.prefetchMutex.Unlock()
+
+		task.file.fetchBlockRange(blockIDs)
+	}
+}
+
+// popQueuedBlock removes and returns the queued task for (file, blockID)
+// if one is waiting in the heap. Callers must hold c.prefetchMutex.
+func (c *Cache) popQueuedBlock(file *File, blockID int64) *fetchTask {
+	key := fetchKey{name: file.name, blockID: blockID}
+	if !c.prefetchQueued[key] {
+		return nil
+	}
+
+	for _, task := range c.prefetchHeap {
+		if task.file == file && task.blockID == blockID {
+			heap.Remove(&c.prefetchHeap, task.heapIndex)
+			delete(c.prefetchQueued, key)
+			return task
+		}
+	}
+
+	return nil
+}
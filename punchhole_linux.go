@@ -0,0 +1,27 @@
+//go:build linux
+
+package mediacache
+
+import (
+	"os"
+	"syscall"
+)
+
+// fallocFlPunchHole and fallocFlKeepSize mirror the constants of the
+// same name from <linux/falloc.h>; they're not exposed by the syscall
+// package so we define them ourselves.
+const (
+	fallocFlPunchHole = 0x02
+	fallocFlKeepSize  = 0x01
+)
+
+// punchHole deallocates the [offset, offset+length) region of f on disk
+// while keeping the file's apparent size unchanged, so the mmap region
+// reads back as zeroes without the bytes occupying space on disk.
+func punchHole(f *os.File, offset, length int64) error {
+	if length <= 0 {
+		return nil
+	}
+
+	return syscall.Fallocate(int(f.Fd()), fallocFlPunchHole|fallocFlKeepSize, offset, length)
+}
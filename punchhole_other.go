@@ -0,0 +1,12 @@
+//go:build !linux
+
+package mediacache
+
+import "os"
+
+// punchHole is a no-op on platforms without hole-punching support; the
+// block is still zeroed in memory and marked for refetch, it just
+// doesn't reclaim disk space until the whole file is removed.
+func punchHole(f *os.File, offset, length int64) error {
+	return nil
+}